@@ -0,0 +1,124 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+    "crypto/cipher"
+    "crypto/subtle"
+    "encoding/binary"
+    "errors"
+)
+
+const (
+    aeadNonceSize = 12
+    aeadTagSize   = 16
+    aeadRounds    = 20
+)
+
+// chacha20poly1305 implements crypto/cipher.AEAD on top of NewIETF and
+// poly1305, following the construction in RFC 7539 section 2.8.
+type chacha20poly1305 struct {
+    key [32]byte
+}
+
+var _ cipher.AEAD = (*chacha20poly1305)(nil)
+
+// NewChaCha20Poly1305 returns a ChaCha20-Poly1305 AEAD (RFC 7539) built
+// from this package's IETF cipher and a Poly1305 authenticator. It uses
+// the 12-byte IETF nonce layout; len(key) must be 32.
+func NewChaCha20Poly1305(key []byte) (cipher.AEAD, error) {
+    if len(key) != 32 {
+        return nil, errors.New("chacha: NewChaCha20Poly1305 requires a 32-byte key")
+    }
+    a := new(chacha20poly1305)
+    copy(a.key[:], key)
+    return a, nil
+}
+
+func (a *chacha20poly1305) NonceSize() int { return aeadNonceSize }
+func (a *chacha20poly1305) Overhead() int  { return aeadTagSize }
+
+// cipherAndPolyKey builds the IETF cipher for nonce and derives the
+// one-time Poly1305 key from the first 32 bytes of block 0, discarding
+// the unused remainder so the returned cipher is positioned to encrypt
+// starting at block 1, as RFC 7539 requires.
+func (a *chacha20poly1305) cipherAndPolyKey(nonce []byte) (*Cipher, [32]byte) {
+    c := NewIETF(a.key[:], nonce, aeadRounds)
+    var polyKey [32]byte
+    c.MustXORKeyStream(polyKey[:], polyKey[:])
+    c.len -= blockSize - len(polyKey) // discard the rest of block 0
+    return c, polyKey
+}
+
+// authInput assembles the Poly1305 input: aad, padded to a 16-byte
+// boundary, followed by ciphertext likewise padded, followed by the
+// 64-bit little-endian lengths of each, per RFC 7539 section 2.8.
+func authInput(aad, ciphertext []byte) []byte {
+    pad := func(n int) int {
+        if n%16 == 0 {
+            return 0
+        }
+        return 16 - n%16
+    }
+    buf := make([]byte, 0, len(aad)+pad(len(aad))+len(ciphertext)+pad(len(ciphertext))+16)
+    buf = append(buf, aad...)
+    buf = append(buf, make([]byte, pad(len(aad)))...)
+    buf = append(buf, ciphertext...)
+    buf = append(buf, make([]byte, pad(len(ciphertext)))...)
+    var lens [16]byte
+    binary.LittleEndian.PutUint64(lens[0:], uint64(len(aad)))
+    binary.LittleEndian.PutUint64(lens[8:], uint64(len(ciphertext)))
+    return append(buf, lens[:]...)
+}
+
+func (a *chacha20poly1305) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+    if len(nonce) != aeadNonceSize {
+        panic("chacha: incorrect nonce length given to ChaCha20Poly1305.Seal")
+    }
+
+    c, polyKey := a.cipherAndPolyKey(nonce)
+
+    ret, out := sliceForAppend(dst, len(plaintext)+aeadTagSize)
+    ciphertext, tag := out[:len(plaintext)], out[len(plaintext):]
+    c.MustXORKeyStream(ciphertext, plaintext)
+
+    mac := poly1305Sum(polyKey, authInput(additionalData, ciphertext))
+    copy(tag, mac[:])
+    return ret
+}
+
+func (a *chacha20poly1305) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+    if len(nonce) != aeadNonceSize {
+        panic("chacha: incorrect nonce length given to ChaCha20Poly1305.Open")
+    }
+    if len(ciphertext) < aeadTagSize {
+        return nil, errors.New("chacha: ciphertext too short")
+    }
+    tag := ciphertext[len(ciphertext)-aeadTagSize:]
+    ciphertext = ciphertext[:len(ciphertext)-aeadTagSize]
+
+    c, polyKey := a.cipherAndPolyKey(nonce)
+
+    wantTag := poly1305Sum(polyKey, authInput(additionalData, ciphertext))
+    if subtle.ConstantTimeCompare(wantTag[:], tag) != 1 {
+        return nil, errors.New("chacha: message authentication failed")
+    }
+
+    ret, out := sliceForAppend(dst, len(ciphertext))
+    c.MustXORKeyStream(out, ciphertext)
+    return ret, nil
+}
+
+// sliceForAppend extends in by n bytes, reusing its backing array when
+// there is enough capacity, and returns both the extended slice and
+// the newly appended tail.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+    if total := len(in) + n; cap(in) >= total {
+        head = in[:total]
+    } else {
+        head = make([]byte, total)
+        copy(head, in)
+    }
+    tail = head[len(in):]
+    return
+}
@@ -15,15 +15,34 @@ import (
 // Ron Charlton re-coded method 'next' to use simple variables instead of an
 // array, for 30% less execution time on an M2 Max Mac Studio. Public domain,
 // 2024-11-10.
+// Ron Charlton reworked block generation to fill a 4-block buffer and
+// added a batched XORKeyStream fast path, public domain, 2024-12-02.
+
+// Widths supported by Cipher.counterBits. New uses counterBits64 (the
+// original 64-bit counter spanning input[12..13]); NewIETF and NewX use
+// counterBits32, the RFC 7539 layout where only input[12] counts blocks
+// and input[13..15] hold the nonce.
+const (
+    counterBits32 = 32
+    counterBits64 = 64
+)
+
+const (
+    blockSize = 64
+    bufSize   = 4 * blockSize
+)
+
+var errExhausted = errors.New("exhausted keystream")
 
 // Cipher is an instance of the ChaCha stream cipher. It implements both
 // the io.Reader and crypto/cipher.Stream interfaces.
 type Cipher struct {
-    input    [16]uint32
-    output   [64]byte
-    nextByte int
-    rounds   int
-    eof      bool
+    input       [16]uint32
+    buf         [bufSize]byte // unused keystream bytes, held at the tail
+    len         int           // number of unused bytes in buf
+    rounds      int
+    counterBits int
+    eof         bool
 }
 
 var _ cipher.Stream = (*Cipher)(nil)
@@ -49,192 +68,113 @@ func New(key, iv []byte, rounds int) *Cipher {
     c.input[14] = binary.LittleEndian.Uint32(iv[0:])
     c.input[15] = binary.LittleEndian.Uint32(iv[4:])
     c.rounds = rounds
-    c.nextByte = len(c.output)
+    c.counterBits = counterBits64
     return c
 }
 
-// Fills the output field with the next block and sets nextByte accordingly.
-func (c *Cipher) next() error {
-    var t uint32
-    if c.eof {
-        return errors.New("exhausted keystream")
-    }
-    a := c.input[0]
-    b := c.input[1]
-    c1 := c.input[2]
-    d := c.input[3]
-    e := c.input[4]
-    f := c.input[5]
-    g := c.input[6]
-    h := c.input[7]
-    i := c.input[8]
-    j := c.input[9]
-    k := c.input[10]
-    l := c.input[11]
-    m := c.input[12]
-    n := c.input[13]
-    o := c.input[14]
-    p := c.input[15]
-
-    for z := c.rounds; z > 0; z -= 2 {
-        a += e
-        t = m ^ a
-        m = (t << 16) | (t >> (32 - 16))
-        i += m
-        t = e ^ i
-        e = (t << 12) | (t >> (32 - 12))
-        a += e
-        t = m ^ a
-        m = (t << 8) | (t >> (32 - 8))
-        i += m
-        t = e ^ i
-        e = (t << 7) | (t >> (32 - 7))
+// block computes one ChaCha block from the current state into out via
+// the active blockCore implementation, then advances the block
+// counter.
+func (c *Cipher) block(out *[blockSize]byte) {
+    blockCore(&c.input, c.rounds, out)
 
-        b += f
-        t = n ^ b
-        n = (t << 16) | (t >> (32 - 16))
-        j += n
-        t = f ^ j
-        f = (t << 12) | (t >> (32 - 12))
-        b += f
-        t = n ^ b
-        n = (t << 8) | (t >> (32 - 8))
-        j += n
-        t = f ^ j
-        f = (t << 7) | (t >> (32 - 7))
-
-        c1 += g
-        t = o ^ c1
-        o = (t << 16) | (t >> (32 - 16))
-        k += o
-        t = g ^ k
-        g = (t << 12) | (t >> (32 - 12))
-        c1 += g
-        t = o ^ c1
-        o = (t << 8) | (t >> (32 - 8))
-        k += o
-        t = g ^ k
-        g = (t << 7) | (t >> (32 - 7))
-
-        d += h
-        t = p ^ d
-        p = (t << 16) | (t >> (32 - 16))
-        l += p
-        t = h ^ l
-        h = (t << 12) | (t >> (32 - 12))
-        d += h
-        t = p ^ d
-        p = (t << 8) | (t >> (32 - 8))
-        l += p
-        t = h ^ l
-        h = (t << 7) | (t >> (32 - 7))
-
-        a += f
-        t = p ^ a
-        p = (t << 16) | (t >> (32 - 16))
-        k += p
-        t = f ^ k
-        f = (t << 12) | (t >> (32 - 12))
-        a += f
-        t = p ^ a
-        p = (t << 8) | (t >> (32 - 8))
-        k += p
-        t = f ^ k
-        f = (t << 7) | (t >> (32 - 7))
-
-        b += g
-        t = m ^ b
-        m = (t << 16) | (t >> (32 - 16))
-        l += m
-        t = g ^ l
-        g = (t << 12) | (t >> (32 - 12))
-        b += g
-        t = m ^ b
-        m = (t << 8) | (t >> (32 - 8))
-        l += m
-        t = g ^ l
-        g = (t << 7) | (t >> (32 - 7))
-
-        c1 += h
-        t = n ^ c1
-        n = (t << 16) | (t >> (32 - 16))
-        i += n
-        t = h ^ i
-        h = (t << 12) | (t >> (32 - 12))
-        c1 += h
-        t = n ^ c1
-        n = (t << 8) | (t >> (32 - 8))
-        i += n
-        t = h ^ i
-        h = (t << 7) | (t >> (32 - 7))
-
-        d += e
-        t = o ^ d
-        o = (t << 16) | (t >> (32 - 16))
-        j += o
-        t = e ^ j
-        e = (t << 12) | (t >> (32 - 12))
-        d += e
-        t = o ^ d
-        o = (t << 8) | (t >> (32 - 8))
-        j += o
-        t = e ^ j
-        e = (t << 7) | (t >> (32 - 7))
+    // Update block counter. A 32-bit counter (RFC 7539 layout) only
+    // advances input[12], leaving the nonce words input[13..15] intact;
+    // the original 64-bit counter spans input[12..13] as before.
+    if c.counterBits == counterBits32 {
+        ctr := c.input[12] + 1
+        c.input[12] = ctr
+        if ctr == 0 {
+            c.eof = true
+        }
+    } else {
+        ctr := (uint64(c.input[13])<<32 | uint64(c.input[12])) + 1
+        if ctr == 0 {
+            c.eof = true
+        }
+        c.input[12] = uint32(ctr)
+        c.input[13] = uint32(ctr >> 32)
     }
+}
 
-    a += c.input[0]
-    binary.LittleEndian.PutUint32(c.output[4*0:], a)
-    b += c.input[1]
-    binary.LittleEndian.PutUint32(c.output[4*1:], b)
-    c1 += c.input[2]
-    binary.LittleEndian.PutUint32(c.output[4*2:], c1)
-    d += c.input[3]
-    binary.LittleEndian.PutUint32(c.output[4*3:], d)
-    e += c.input[4]
-    binary.LittleEndian.PutUint32(c.output[4*4:], e)
-    f += c.input[5]
-    binary.LittleEndian.PutUint32(c.output[4*5:], f)
-    g += c.input[6]
-    binary.LittleEndian.PutUint32(c.output[4*6:], g)
-    h += c.input[7]
-    binary.LittleEndian.PutUint32(c.output[4*7:], h)
-    i += c.input[8]
-    binary.LittleEndian.PutUint32(c.output[4*8:], i)
-    j += c.input[9]
-    binary.LittleEndian.PutUint32(c.output[4*9:], j)
-    k += c.input[10]
-    binary.LittleEndian.PutUint32(c.output[4*10:], k)
-    l += c.input[11]
-    binary.LittleEndian.PutUint32(c.output[4*11:], l)
-    m += c.input[12]
-    binary.LittleEndian.PutUint32(c.output[4*12:], m)
-    n += c.input[13]
-    binary.LittleEndian.PutUint32(c.output[4*13:], n)
-    o += c.input[14]
-    binary.LittleEndian.PutUint32(c.output[4*14:], o)
-    p += c.input[15]
-    binary.LittleEndian.PutUint32(c.output[4*15:], p)
-
-    // Update block counter
-    ctr := (uint64(c.input[13])<<32 | uint64(c.input[12])) + 1
-    if ctr == 0 {
-        c.eof = true
+// fillBuffer generates up to bufSize bytes (4 blocks) of fresh keystream
+// into c.buf, moving it to the tail of c.buf so unused bytes are always
+// found at c.buf[bufSize-c.len:]. It stops early, with fewer than
+// bufSize bytes available, if the keystream is exhausted mid-buffer.
+func (c *Cipher) fillBuffer() error {
+    if c.eof {
+        return errExhausted
     }
-    c.input[12] = uint32(ctr)
-    c.input[13] = uint32(ctr >> 32)
-
-    c.nextByte = 0
+    var blk [blockSize]byte
+    n := 0
+    for n < bufSize && !c.eof {
+        c.block(&blk)
+        copy(c.buf[n:n+blockSize], blk[:])
+        n += blockSize
+    }
+    if n < bufSize {
+        copy(c.buf[bufSize-n:], c.buf[:n])
+    }
+    c.len = n
     return nil
 }
 
+// xorKeyStreamBlocks XORs src into dst directly from freshly generated
+// keystream, a block at a time, without copying through an intermediate
+// buffer, and returns the number of bytes written so far even when it
+// stops early because the keystream was exhausted. len(src) must be a
+// multiple of blockSize; callers in this file only ever pass a multiple
+// of bufSize, but that is not required here.
+func (c *Cipher) xorKeyStreamBlocks(dst, src []byte) (int, error) {
+    var blk [blockSize]byte
+    n := 0
+    for len(src) > 0 {
+        if c.eof {
+            return n, errExhausted
+        }
+        c.block(&blk)
+        for i := 0; i < blockSize; i++ {
+            dst[i] = src[i] ^ blk[i]
+        }
+        dst, src = dst[blockSize:], src[blockSize:]
+        n += blockSize
+    }
+    return n, nil
+}
+
 // Seek sets the cipher's internal stream position to the nth 64-byte
 // block. For example, Seek(0) sets the cipher back to its initial
-// state.
+// state. It is equivalent to SetCounter(n).
 func (c *Cipher) Seek(n uint64) {
+    c.SetCounter(n)
+}
+
+// Counter returns the cipher's current block counter.
+func (c *Cipher) Counter() uint64 {
+    if c.counterBits == counterBits32 {
+        return uint64(c.input[12])
+    }
+    return uint64(c.input[13])<<32 | uint64(c.input[12])
+}
+
+// SetCounter sets the cipher's block counter to n, discards any
+// buffered keystream, and clears AtEOF. Ciphers with a 32-bit counter
+// (NewIETF, NewX) only store the low 32 bits of n, leaving the nonce
+// words input[13..15] untouched.
+func (c *Cipher) SetCounter(n uint64) {
     c.input[12] = uint32(n)
-    c.input[13] = uint32(n >> 32)
+    if c.counterBits == counterBits64 {
+        c.input[13] = uint32(n >> 32)
+    }
     c.eof = false
-    c.next() // always succeeds
+    c.len = 0
+}
+
+// AtEOF reports whether the keystream has been exhausted: after 2^70
+// bytes of output for a 64-bit counter (New), or after 2^32 blocks
+// (256 GiB) for a 32-bit counter (NewIETF, NewX).
+func (c *Cipher) AtEOF() bool {
+    return c.eof
 }
 
 // Read implements io.Reader.Read(). After 2^70 bytes of output the
@@ -242,28 +182,102 @@ func (c *Cipher) Seek(n uint64) {
 // error. There are no other error conditions.
 func (c *Cipher) Read(p []byte) (int, error) {
     n := 0
-    for ; n < len(p); n++ {
-        if c.nextByte >= len(c.output) {
-            if err := c.next(); err != nil {
+    for n < len(p) {
+        if c.len == 0 {
+            if err := c.fillBuffer(); err != nil {
                 return n, io.EOF
             }
         }
-        p[n] = c.output[c.nextByte]
-        c.nextByte++
+        k := copy(p[n:], c.buf[bufSize-c.len:])
+        c.len -= k
+        n += k
     }
     return n, nil
 }
 
-// XORKeyStream implements crypto/cipher.Cipher. It will panic when the
-// keystream has been exhausted.
+// XORKeyStreamErr is like XORKeyStream, but instead of zeroing the
+// unwritten tail of dst when the keystream is exhausted before all of
+// src is consumed, it stops and returns the number of bytes written
+// along with a non-nil error.
+// Input is consumed from any buffered keystream left over from a
+// previous call, then XORed directly from freshly generated blocks in
+// bufSize-aligned chunks, then (for a remainder shorter than bufSize)
+// from a freshly filled buffer.
+func (c *Cipher) XORKeyStreamErr(dst, src []byte) (int, error) {
+    if len(dst) < len(src) {
+        return 0, errors.New("chacha: output smaller than input")
+    }
+    dst = dst[:len(src)]
+    total := len(src)
+
+    if c.len != 0 {
+        keyStream := c.buf[bufSize-c.len:]
+        n := len(src)
+        if n > len(keyStream) {
+            n = len(keyStream)
+        }
+        for i := 0; i < n; i++ {
+            dst[i] = src[i] ^ keyStream[i]
+        }
+        c.len -= n
+        dst, src = dst[n:], src[n:]
+    }
+    if len(src) == 0 {
+        return total, nil
+    }
+
+    if full := len(src) - len(src)%bufSize; full > 0 {
+        n, err := c.xorKeyStreamBlocks(dst[:full], src[:full])
+        if err != nil {
+            return total - len(src) + n, err
+        }
+        dst, src = dst[full:], src[full:]
+    }
+    if len(src) == 0 {
+        return total, nil
+    }
+
+    if err := c.fillBuffer(); err != nil {
+        return total - len(src), err
+    }
+    keyStream := c.buf[bufSize-c.len:]
+    n := len(src)
+    if n > len(keyStream) {
+        n = len(keyStream)
+    }
+    for i := 0; i < n; i++ {
+        dst[i] = src[i] ^ keyStream[i]
+    }
+    c.len -= n
+    if n < len(src) {
+        return total - len(src) + n, errExhausted
+    }
+    return total, nil
+}
+
+// XORKeyStream implements crypto/cipher.Stream. BEWARE: crypto/cipher.Stream
+// has no way to report an error, so if the keystream is exhausted before all
+// of src is consumed, this method cannot signal that to the caller the way
+// XORKeyStreamErr does. In particular, the common in-place call
+// c.XORKeyStream(buf, buf) would otherwise leave unencrypted plaintext
+// sitting in the unwritten tail of buf with no indication anything went
+// wrong; to fail safe instead, XORKeyStream zeroes that tail rather than
+// leaving src's bytes in it. Callers that must detect exhaustion need
+// XORKeyStreamErr or MustXORKeyStream.
 func (c *Cipher) XORKeyStream(dst, src []byte) {
-    for i := 0; i < len(dst); i++ {
-        if c.nextByte >= len(c.output) {
-            if err := c.next(); err != nil {
-                panic(err)
-            }
+    n, err := c.XORKeyStreamErr(dst, src)
+    if err != nil {
+        for i := n; i < len(src); i++ {
+            dst[i] = 0
         }
-        dst[i] = src[i] ^ c.output[c.nextByte]
-        c.nextByte++
+    }
+}
+
+// MustXORKeyStream is XORKeyStreamErr, but panics instead of returning
+// an error when the keystream is exhausted before all of src is
+// consumed.
+func (c *Cipher) MustXORKeyStream(dst, src []byte) {
+    if _, err := c.XORKeyStreamErr(dst, src); err != nil {
+        panic(err)
     }
 }
@@ -0,0 +1,21 @@
+// This is free and unencumbered software released into the public domain.
+
+//go:build amd64 && !purego
+
+package chacha
+
+import "golang.org/x/sys/cpu"
+
+func init() {
+    if cpu.X86.HasSSSE3 {
+        blockCore = blockCoreAMD64
+    }
+}
+
+// blockCoreAMD64 is the SSSE3 implementation of blockCore, in
+// chacha_amd64.s. It computes one block, vectorizing the four words of
+// that single block across SSE lanes; it does not batch multiple
+// blocks in parallel, so fillBuffer still calls it once per block. A
+// true multi-block (4x/8x, AVX2) core, as originally envisioned for
+// this dispatch mechanism, remains future work.
+func blockCoreAMD64(input *[16]uint32, rounds int, out *[blockSize]byte)
@@ -0,0 +1,19 @@
+// This is free and unencumbered software released into the public domain.
+
+//go:build arm64 && !purego
+
+package chacha
+
+import "golang.org/x/sys/cpu"
+
+func init() {
+    if cpu.ARM64.HasASIMD {
+        blockCore = blockCoreARM64
+    }
+}
+
+// blockCoreARM64 is the NEON implementation of blockCore, in
+// chacha_arm64.s. Like blockCoreAMD64, it vectorizes the four words of
+// a single block across lanes rather than batching multiple blocks in
+// parallel; fillBuffer still calls it once per block.
+func blockCoreARM64(input *[16]uint32, rounds int, out *[blockSize]byte)
@@ -0,0 +1,22 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import "testing"
+
+func benchmarkXORKeyStream(b *testing.B, size int) {
+    key := make([]byte, 32)
+    iv := make([]byte, 8)
+    c := New(key, iv, 20)
+    buf := make([]byte, size)
+    b.SetBytes(int64(size))
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        c.XORKeyStream(buf, buf)
+    }
+}
+
+func BenchmarkXORKeyStream64(b *testing.B)    { benchmarkXORKeyStream(b, 64) }
+func BenchmarkXORKeyStream256(b *testing.B)   { benchmarkXORKeyStream(b, 256) }
+func BenchmarkXORKeyStream1024(b *testing.B)  { benchmarkXORKeyStream(b, 1024) }
+func BenchmarkXORKeyStream64k(b *testing.B)   { benchmarkXORKeyStream(b, 65536) }
@@ -0,0 +1,177 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import "encoding/binary"
+
+// blockCore computes one ChaCha block (running the given number of
+// rounds) from the 16-word state in input into out, without touching
+// the block counter; counter advancement is the caller's job, since it
+// depends on counterBits and is identical across backends. It is
+// selected once at package init time to the fastest implementation
+// available for the running architecture, defaulting to this portable
+// Go core; see chacha_amd64.go and chacha_arm64.go. Every backend,
+// including the amd64/arm64 SIMD cores, computes exactly one block per
+// call: fillBuffer calls blockCore once per block even when filling the
+// 4-block batch buffer, since none of these implementations batch
+// multiple blocks in a single vectorized call.
+var blockCore = genericBlockCore
+
+func genericBlockCore(input *[16]uint32, rounds int, out *[blockSize]byte) {
+    var t uint32
+    a := input[0]
+    b := input[1]
+    c1 := input[2]
+    d := input[3]
+    e := input[4]
+    f := input[5]
+    g := input[6]
+    h := input[7]
+    i := input[8]
+    j := input[9]
+    k := input[10]
+    l := input[11]
+    m := input[12]
+    n := input[13]
+    o := input[14]
+    p := input[15]
+
+    for z := rounds; z > 0; z -= 2 {
+        a += e
+        t = m ^ a
+        m = (t << 16) | (t >> (32 - 16))
+        i += m
+        t = e ^ i
+        e = (t << 12) | (t >> (32 - 12))
+        a += e
+        t = m ^ a
+        m = (t << 8) | (t >> (32 - 8))
+        i += m
+        t = e ^ i
+        e = (t << 7) | (t >> (32 - 7))
+
+        b += f
+        t = n ^ b
+        n = (t << 16) | (t >> (32 - 16))
+        j += n
+        t = f ^ j
+        f = (t << 12) | (t >> (32 - 12))
+        b += f
+        t = n ^ b
+        n = (t << 8) | (t >> (32 - 8))
+        j += n
+        t = f ^ j
+        f = (t << 7) | (t >> (32 - 7))
+
+        c1 += g
+        t = o ^ c1
+        o = (t << 16) | (t >> (32 - 16))
+        k += o
+        t = g ^ k
+        g = (t << 12) | (t >> (32 - 12))
+        c1 += g
+        t = o ^ c1
+        o = (t << 8) | (t >> (32 - 8))
+        k += o
+        t = g ^ k
+        g = (t << 7) | (t >> (32 - 7))
+
+        d += h
+        t = p ^ d
+        p = (t << 16) | (t >> (32 - 16))
+        l += p
+        t = h ^ l
+        h = (t << 12) | (t >> (32 - 12))
+        d += h
+        t = p ^ d
+        p = (t << 8) | (t >> (32 - 8))
+        l += p
+        t = h ^ l
+        h = (t << 7) | (t >> (32 - 7))
+
+        a += f
+        t = p ^ a
+        p = (t << 16) | (t >> (32 - 16))
+        k += p
+        t = f ^ k
+        f = (t << 12) | (t >> (32 - 12))
+        a += f
+        t = p ^ a
+        p = (t << 8) | (t >> (32 - 8))
+        k += p
+        t = f ^ k
+        f = (t << 7) | (t >> (32 - 7))
+
+        b += g
+        t = m ^ b
+        m = (t << 16) | (t >> (32 - 16))
+        l += m
+        t = g ^ l
+        g = (t << 12) | (t >> (32 - 12))
+        b += g
+        t = m ^ b
+        m = (t << 8) | (t >> (32 - 8))
+        l += m
+        t = g ^ l
+        g = (t << 7) | (t >> (32 - 7))
+
+        c1 += h
+        t = n ^ c1
+        n = (t << 16) | (t >> (32 - 16))
+        i += n
+        t = h ^ i
+        h = (t << 12) | (t >> (32 - 12))
+        c1 += h
+        t = n ^ c1
+        n = (t << 8) | (t >> (32 - 8))
+        i += n
+        t = h ^ i
+        h = (t << 7) | (t >> (32 - 7))
+
+        d += e
+        t = o ^ d
+        o = (t << 16) | (t >> (32 - 16))
+        j += o
+        t = e ^ j
+        e = (t << 12) | (t >> (32 - 12))
+        d += e
+        t = o ^ d
+        o = (t << 8) | (t >> (32 - 8))
+        j += o
+        t = e ^ j
+        e = (t << 7) | (t >> (32 - 7))
+    }
+
+    a += input[0]
+    binary.LittleEndian.PutUint32(out[4*0:], a)
+    b += input[1]
+    binary.LittleEndian.PutUint32(out[4*1:], b)
+    c1 += input[2]
+    binary.LittleEndian.PutUint32(out[4*2:], c1)
+    d += input[3]
+    binary.LittleEndian.PutUint32(out[4*3:], d)
+    e += input[4]
+    binary.LittleEndian.PutUint32(out[4*4:], e)
+    f += input[5]
+    binary.LittleEndian.PutUint32(out[4*5:], f)
+    g += input[6]
+    binary.LittleEndian.PutUint32(out[4*6:], g)
+    h += input[7]
+    binary.LittleEndian.PutUint32(out[4*7:], h)
+    i += input[8]
+    binary.LittleEndian.PutUint32(out[4*8:], i)
+    j += input[9]
+    binary.LittleEndian.PutUint32(out[4*9:], j)
+    k += input[10]
+    binary.LittleEndian.PutUint32(out[4*10:], k)
+    l += input[11]
+    binary.LittleEndian.PutUint32(out[4*11:], l)
+    m += input[12]
+    binary.LittleEndian.PutUint32(out[4*12:], m)
+    n += input[13]
+    binary.LittleEndian.PutUint32(out[4*13:], n)
+    o += input[14]
+    binary.LittleEndian.PutUint32(out[4*14:], o)
+    p += input[15]
+    binary.LittleEndian.PutUint32(out[4*15:], p)
+}
@@ -0,0 +1,195 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+    "bytes"
+    "encoding/hex"
+    "testing"
+)
+
+// RFC 8439 section 2.3.2 ChaCha20 block function test vector. Run
+// without a build tag so it always exercises whichever backend
+// (blockCoreAMD64, blockCoreARM64, or genericBlockCore) init selected
+// for the running CPU.
+func TestIETFBlockVector(t *testing.T) {
+    key := make([]byte, 32)
+    for i := range key {
+        key[i] = byte(i)
+    }
+    nonce := []byte{0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x4a, 0x00, 0x00, 0x00, 0x00}
+
+    c := NewIETF(key, nonce, 20)
+    c.SetCounter(1)
+
+    want, err := hex.DecodeString(
+        "10f1e7e4d13b5915500fdd1fa32071c4" +
+            "c7d1f4c733c068030422aa9ac3d46c4e" +
+            "d2826446079faa0914c2d705d98b02a2" +
+            "b5129cd1de164eb9cbd083e8a2503c4e")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    got := make([]byte, len(want))
+    if _, err := c.Read(got); err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(got, want) {
+        t.Fatalf("block mismatch:\n got=%x\nwant=%x", got, want)
+    }
+}
+
+// HChaCha20 test vector from draft-irtf-cfrg-xchacha-03 appendix A.2.
+func TestHChaCha20Vector(t *testing.T) {
+    key := make([]byte, 32)
+    for i := range key {
+        key[i] = byte(i)
+    }
+    nonce := []byte{
+        0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x4a,
+        0x00, 0x00, 0x00, 0x00, 0x31, 0x41, 0x59, 0x27,
+    }
+    want, err := hex.DecodeString(
+        "82413b4227b27bfed30e42508a877d73" +
+            "a0f9e4d58a74a853c12ec41326d3ecdc")
+    if err != nil {
+        t.Fatal(err)
+    }
+    got := HChaCha20(key, nonce)
+    if !bytes.Equal(got[:], want) {
+        t.Fatalf("HChaCha20 mismatch:\n got=%x\nwant=%x", got, want)
+    }
+}
+
+// Poly1305 test vector from RFC 8439 section 2.5.2.
+func TestPoly1305Vector(t *testing.T) {
+    var key [32]byte
+    k, err := hex.DecodeString(
+        "85d6be7857556d337f4452fe42d506a8" +
+            "0103808afb0db2fd4abff6af4149f51b")
+    if err != nil {
+        t.Fatal(err)
+    }
+    copy(key[:], k)
+
+    msg := []byte("Cryptographic Forum Research Group")
+    want, err := hex.DecodeString("a8061dc1305136c6c22b8baf0c0127a9")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    got := poly1305Sum(key, msg)
+    if !bytes.Equal(got[:], want) {
+        t.Fatalf("Poly1305 tag mismatch:\n got=%x\nwant=%x", got, want)
+    }
+}
+
+// ChaCha20-Poly1305 AEAD test vector from RFC 8439 section 2.8.2.
+func TestChaCha20Poly1305Vector(t *testing.T) {
+    key, err := hex.DecodeString(
+        "808182838485868788898a8b8c8d8e8f" +
+            "909192939495969798999a9b9c9d9e9f")
+    if err != nil {
+        t.Fatal(err)
+    }
+    nonce, err := hex.DecodeString("070000004041424344454647")
+    if err != nil {
+        t.Fatal(err)
+    }
+    aad, err := hex.DecodeString("50515253c0c1c2c3c4c5c6c7")
+    if err != nil {
+        t.Fatal(err)
+    }
+    plaintext := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+    wantCiphertext, err := hex.DecodeString(
+        "d31a8d34648e60db7b86afbc53ef7ec2" +
+            "a4aded51296e08fea9e2b5a736ee62d6" +
+            "3dbea45e8ca9671282fafb69da92728b" +
+            "1a71de0a9e060b2905d6a5b67ecd3b36" +
+            "92ddbd7f2d778b8c9803aee328091b58" +
+            "fab324e4fad675945585808b4831d7bc" +
+            "3ff4def08e4b7a9de576d26586cec64b" +
+            "6116")
+    if err != nil {
+        t.Fatal(err)
+    }
+    wantTag, err := hex.DecodeString("1ae10b594f09e26a7e902ecbd0600691")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    aead, err := NewChaCha20Poly1305(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    sealed := aead.Seal(nil, nonce, plaintext, aad)
+    wantSealed := append(append([]byte{}, wantCiphertext...), wantTag...)
+    if !bytes.Equal(sealed, wantSealed) {
+        t.Fatalf("Seal mismatch:\n got=%x\nwant=%x", sealed, wantSealed)
+    }
+
+    opened, err := aead.Open(nil, nonce, sealed, aad)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(opened, plaintext) {
+        t.Fatalf("Open mismatch:\n got=%x\nwant=%x", opened, plaintext)
+    }
+}
+
+// When the keystream runs out mid-call, XORKeyStreamErr must report how
+// many bytes of dst actually hold valid ciphertext (even when it is the
+// bufSize-aligned xorKeyStreamBlocks fast path that hits exhaustion),
+// and XORKeyStream must zero only the unwritten tail, preserving that
+// valid prefix rather than leaving src's original bytes in it.
+func TestXORKeyStreamExhaustion(t *testing.T) {
+    key := make([]byte, 32)
+    nonce := make([]byte, 12)
+    const validBlocks = 2 // counters fe, ff are the only blocks left
+
+    want := make([]byte, blockSize*validBlocks)
+    ref := NewIETF(key, nonce, 20)
+    ref.SetCounter(0xfffffffe)
+    if _, err := ref.Read(want); err != nil {
+        t.Fatal(err)
+    }
+
+    src := bytes.Repeat([]byte{0x42}, blockSize*4)
+    wantPrefix := make([]byte, len(want))
+    for i := range wantPrefix {
+        wantPrefix[i] = src[i] ^ want[i]
+    }
+
+    c := NewIETF(key, nonce, 20)
+    c.SetCounter(0xfffffffe)
+    dst := make([]byte, len(src))
+    n, err := c.XORKeyStreamErr(dst, src)
+    if err == nil {
+        t.Fatal("expected exhaustion error")
+    }
+    if n != len(wantPrefix) {
+        t.Fatalf("n = %d, want %d", n, len(wantPrefix))
+    }
+    if !bytes.Equal(dst[:n], wantPrefix) {
+        t.Fatalf("XORKeyStreamErr prefix mismatch:\n got=%x\nwant=%x", dst[:n], wantPrefix)
+    }
+
+    c2 := NewIETF(key, nonce, 20)
+    c2.SetCounter(0xfffffffe)
+    dst2 := make([]byte, len(src))
+    c2.XORKeyStream(dst2, src)
+
+    if !c2.AtEOF() {
+        t.Fatal("expected keystream to be exhausted")
+    }
+    if !bytes.Equal(dst2[:len(wantPrefix)], wantPrefix) {
+        t.Fatalf("XORKeyStream prefix mismatch:\n got=%x\nwant=%x", dst2[:len(wantPrefix)], wantPrefix)
+    }
+    for i, b := range dst2[len(wantPrefix):] {
+        if b != 0 {
+            t.Fatalf("dst2[%d] = %#x, want 0 (plaintext leaked into unwritten tail)", len(wantPrefix)+i, b)
+        }
+    }
+}
@@ -0,0 +1,115 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import "encoding/binary"
+
+// NewIETF returns an initialized ChaCha cipher using the 96-bit nonce
+// layout from RFC 7539. Input word 12 is the 32-bit block counter and
+// input words 13..15 hold the nonce, so the keystream wraps at 2^32
+// blocks (256 GiB) rather than the 2^70 bytes available from New.
+// len(key) must be >= 32, len(nonce) must be >= 12, and rounds should
+// be one of 8, 12, or 20.
+func NewIETF(key, nonce []byte, rounds int) *Cipher {
+    c := new(Cipher)
+    c.input[0] = 0x61707865 // "expand 32-byte k"
+    c.input[1] = 0x3320646e //
+    c.input[2] = 0x79622d32 //
+    c.input[3] = 0x6b206574 //
+    c.input[4] = binary.LittleEndian.Uint32(key[0:])
+    c.input[5] = binary.LittleEndian.Uint32(key[4:])
+    c.input[6] = binary.LittleEndian.Uint32(key[8:])
+    c.input[7] = binary.LittleEndian.Uint32(key[12:])
+    c.input[8] = binary.LittleEndian.Uint32(key[16:])
+    c.input[9] = binary.LittleEndian.Uint32(key[20:])
+    c.input[10] = binary.LittleEndian.Uint32(key[24:])
+    c.input[11] = binary.LittleEndian.Uint32(key[28:])
+    c.input[12] = 0
+    c.input[13] = binary.LittleEndian.Uint32(nonce[0:])
+    c.input[14] = binary.LittleEndian.Uint32(nonce[4:])
+    c.input[15] = binary.LittleEndian.Uint32(nonce[8:])
+    c.rounds = rounds
+    c.counterBits = counterBits32
+    return c
+}
+
+// NewX returns an initialized ChaCha cipher using the 192-bit extended
+// nonce from draft-irtf-cfrg-xchacha. The first 16 bytes of nonce24 are
+// run through HChaCha20 with key to derive a 32-byte subkey; the
+// remaining 8 bytes become the last 8 bytes of a standard 96-bit IETF
+// nonce, after 4 leading zero bytes. The extra nonce length all but
+// eliminates the risk of nonce reuse when nonces are chosen at random.
+// len(key) must be >= 32, len(nonce24) must be >= 24, and rounds should
+// be one of 8, 12, or 20.
+func NewX(key, nonce24 []byte, rounds int) *Cipher {
+    subKey := HChaCha20(key, nonce24[0:16])
+    var nonce [12]byte
+    copy(nonce[4:], nonce24[16:24])
+    return NewIETF(subKey[:], nonce[:], rounds)
+}
+
+// HChaCha20 runs the 20-round ChaCha round function over the standard
+// state initialized with key and the first 16 bytes of nonce16 placed
+// in input words 12..15, and returns words 0..3 and 12..15 of the
+// resulting state without the final addition of the original state.
+// It derives the subkey used by NewX, and is exposed for other
+// XChaCha-style constructions built on top of this package. len(key)
+// must be >= 32 and len(nonce16) must be >= 16.
+func HChaCha20(key, nonce16 []byte) [32]byte {
+    var state [16]uint32
+    state[0] = 0x61707865 // "expand 32-byte k"
+    state[1] = 0x3320646e //
+    state[2] = 0x79622d32 //
+    state[3] = 0x6b206574 //
+    state[4] = binary.LittleEndian.Uint32(key[0:])
+    state[5] = binary.LittleEndian.Uint32(key[4:])
+    state[6] = binary.LittleEndian.Uint32(key[8:])
+    state[7] = binary.LittleEndian.Uint32(key[12:])
+    state[8] = binary.LittleEndian.Uint32(key[16:])
+    state[9] = binary.LittleEndian.Uint32(key[20:])
+    state[10] = binary.LittleEndian.Uint32(key[24:])
+    state[11] = binary.LittleEndian.Uint32(key[28:])
+    state[12] = binary.LittleEndian.Uint32(nonce16[0:])
+    state[13] = binary.LittleEndian.Uint32(nonce16[4:])
+    state[14] = binary.LittleEndian.Uint32(nonce16[8:])
+    state[15] = binary.LittleEndian.Uint32(nonce16[12:])
+
+    for i := 0; i < 10; i++ {
+        hChaChaQuarterRound(&state, 0, 4, 8, 12)
+        hChaChaQuarterRound(&state, 1, 5, 9, 13)
+        hChaChaQuarterRound(&state, 2, 6, 10, 14)
+        hChaChaQuarterRound(&state, 3, 7, 11, 15)
+        hChaChaQuarterRound(&state, 0, 5, 10, 15)
+        hChaChaQuarterRound(&state, 1, 6, 11, 12)
+        hChaChaQuarterRound(&state, 2, 7, 8, 13)
+        hChaChaQuarterRound(&state, 3, 4, 9, 14)
+    }
+
+    var out [32]byte
+    binary.LittleEndian.PutUint32(out[0:], state[0])
+    binary.LittleEndian.PutUint32(out[4:], state[1])
+    binary.LittleEndian.PutUint32(out[8:], state[2])
+    binary.LittleEndian.PutUint32(out[12:], state[3])
+    binary.LittleEndian.PutUint32(out[16:], state[12])
+    binary.LittleEndian.PutUint32(out[20:], state[13])
+    binary.LittleEndian.PutUint32(out[24:], state[14])
+    binary.LittleEndian.PutUint32(out[28:], state[15])
+    return out
+}
+
+// hChaChaQuarterRound applies one ChaCha quarter round to the four
+// state words at indices a, b, c, d.
+func hChaChaQuarterRound(state *[16]uint32, a, b, c, d int) {
+    state[a] += state[b]
+    state[d] ^= state[a]
+    state[d] = state[d]<<16 | state[d]>>16
+    state[c] += state[d]
+    state[b] ^= state[c]
+    state[b] = state[b]<<12 | state[b]>>20
+    state[a] += state[b]
+    state[d] ^= state[a]
+    state[d] = state[d]<<8 | state[d]>>24
+    state[c] += state[d]
+    state[b] ^= state[c]
+    state[b] = state[b]<<7 | state[b]>>25
+}
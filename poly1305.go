@@ -0,0 +1,207 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import "encoding/binary"
+
+// poly1305 computes the RFC 7539 Poly1305 one-time authenticator using
+// fixed-width 26-bit limb arithmetic, following the public-domain
+// poly1305-donna reference by Andrew Moon. Every step here runs in
+// time independent of the secret key r, unlike a math/big
+// implementation, which matters because r is reused across every
+// block of a message.
+type poly1305 struct {
+    r   [5]uint32 // clamped r, split into 26-bit limbs
+    h   [5]uint32 // accumulator, 26-bit limbs
+    pad [4]uint32 // s, as little-endian 32-bit words
+    buf [16]byte  // holds a partial block between update calls
+    n   int       // bytes buffered in buf
+}
+
+// newPoly1305 derives r and s from a 32-byte one-time key as described
+// in RFC 7539 section 2.5.
+func newPoly1305(key [32]byte) *poly1305 {
+    var r [16]byte
+    copy(r[:], key[:16])
+    r[3] &= 15
+    r[7] &= 15
+    r[11] &= 15
+    r[15] &= 15
+    r[4] &= 252
+    r[8] &= 252
+    r[12] &= 252
+
+    t0 := binary.LittleEndian.Uint32(r[0:])
+    t1 := binary.LittleEndian.Uint32(r[4:])
+    t2 := binary.LittleEndian.Uint32(r[8:])
+    t3 := binary.LittleEndian.Uint32(r[12:])
+
+    p := new(poly1305)
+    p.r[0] = t0 & 0x3ffffff
+    p.r[1] = ((t0 >> 26) | (t1 << 6)) & 0x3ffffff
+    p.r[2] = ((t1 >> 20) | (t2 << 12)) & 0x3ffffff
+    p.r[3] = ((t2 >> 14) | (t3 << 18)) & 0x3ffffff
+    p.r[4] = (t3 >> 8) & 0x3ffffff
+
+    p.pad[0] = binary.LittleEndian.Uint32(key[16:])
+    p.pad[1] = binary.LittleEndian.Uint32(key[20:])
+    p.pad[2] = binary.LittleEndian.Uint32(key[24:])
+    p.pad[3] = binary.LittleEndian.Uint32(key[28:])
+    return p
+}
+
+// block folds one 16-byte message block m into the accumulator. hibit
+// is ORed into the top limb above the message bits: 1<<24 for a full
+// block (the implicit 2^128 bit from RFC 7539 section 2.5), or 0 for
+// the final, already-padded partial block.
+func (p *poly1305) block(m []byte, hibit uint32) {
+    r0, r1, r2, r3, r4 := p.r[0], p.r[1], p.r[2], p.r[3], p.r[4]
+    s1, s2, s3, s4 := r1*5, r2*5, r3*5, r4*5
+    h0, h1, h2, h3, h4 := p.h[0], p.h[1], p.h[2], p.h[3], p.h[4]
+
+    t0 := binary.LittleEndian.Uint32(m[0:])
+    t1 := binary.LittleEndian.Uint32(m[4:])
+    t2 := binary.LittleEndian.Uint32(m[8:])
+    t3 := binary.LittleEndian.Uint32(m[12:])
+
+    h0 += t0 & 0x3ffffff
+    h1 += uint32((uint64(t1)<<32|uint64(t0))>>26) & 0x3ffffff
+    h2 += uint32((uint64(t2)<<32|uint64(t1))>>20) & 0x3ffffff
+    h3 += uint32((uint64(t3)<<32|uint64(t2))>>14) & 0x3ffffff
+    h4 += (t3 >> 8) | hibit
+
+    d0 := uint64(h0)*uint64(r0) + uint64(h1)*uint64(s4) + uint64(h2)*uint64(s3) + uint64(h3)*uint64(s2) + uint64(h4)*uint64(s1)
+    d1 := uint64(h0)*uint64(r1) + uint64(h1)*uint64(r0) + uint64(h2)*uint64(s4) + uint64(h3)*uint64(s3) + uint64(h4)*uint64(s2)
+    d2 := uint64(h0)*uint64(r2) + uint64(h1)*uint64(r1) + uint64(h2)*uint64(r0) + uint64(h3)*uint64(s4) + uint64(h4)*uint64(s3)
+    d3 := uint64(h0)*uint64(r3) + uint64(h1)*uint64(r2) + uint64(h2)*uint64(r1) + uint64(h3)*uint64(r0) + uint64(h4)*uint64(s4)
+    d4 := uint64(h0)*uint64(r4) + uint64(h1)*uint64(r3) + uint64(h2)*uint64(r2) + uint64(h3)*uint64(r1) + uint64(h4)*uint64(r0)
+
+    var c uint64
+    c = d0 >> 26
+    h0 = uint32(d0) & 0x3ffffff
+    d1 += c
+    c = d1 >> 26
+    h1 = uint32(d1) & 0x3ffffff
+    d2 += c
+    c = d2 >> 26
+    h2 = uint32(d2) & 0x3ffffff
+    d3 += c
+    c = d3 >> 26
+    h3 = uint32(d3) & 0x3ffffff
+    d4 += c
+    c = d4 >> 26
+    h4 = uint32(d4) & 0x3ffffff
+    h0 += uint32(c) * 5
+    c = uint64(h0 >> 26)
+    h0 &= 0x3ffffff
+    h1 += uint32(c)
+
+    p.h[0], p.h[1], p.h[2], p.h[3], p.h[4] = h0, h1, h2, h3, h4
+}
+
+// update folds msg into the running accumulator, buffering any partial
+// 16-byte block across calls.
+func (p *poly1305) update(msg []byte) {
+    if p.n > 0 {
+        n := copy(p.buf[p.n:], msg)
+        p.n += n
+        msg = msg[n:]
+        if p.n < 16 {
+            return
+        }
+        p.block(p.buf[:], 1<<24)
+        p.n = 0
+    }
+    for len(msg) >= 16 {
+        p.block(msg[:16], 1<<24)
+        msg = msg[16:]
+    }
+    p.n = copy(p.buf[:], msg)
+}
+
+// tag finalizes the authenticator, returning acc+s mod 2^128 in
+// little-endian form.
+func (p *poly1305) tag() [16]byte {
+    if p.n > 0 {
+        var last [16]byte
+        copy(last[:], p.buf[:p.n])
+        last[p.n] = 1 // append the bit 1 above the highest message byte
+        p.block(last[:], 0)
+    }
+
+    h0, h1, h2, h3, h4 := p.h[0], p.h[1], p.h[2], p.h[3], p.h[4]
+
+    var c uint32
+    c = h1 >> 26
+    h1 &= 0x3ffffff
+    h2 += c
+    c = h2 >> 26
+    h2 &= 0x3ffffff
+    h3 += c
+    c = h3 >> 26
+    h3 &= 0x3ffffff
+    h4 += c
+    c = h4 >> 26
+    h4 &= 0x3ffffff
+    h0 += c * 5
+    c = h0 >> 26
+    h0 &= 0x3ffffff
+    h1 += c
+
+    // Compute h - p; select it over h, in constant time, if h >= p.
+    g0 := h0 + 5
+    c = g0 >> 26
+    g0 &= 0x3ffffff
+    g1 := h1 + c
+    c = g1 >> 26
+    g1 &= 0x3ffffff
+    g2 := h2 + c
+    c = g2 >> 26
+    g2 &= 0x3ffffff
+    g3 := h3 + c
+    c = g3 >> 26
+    g3 &= 0x3ffffff
+    g4 := h4 + c - (1 << 26)
+
+    mask := (g4 >> 31) - 1 // all-1s if h >= p, all-0s otherwise
+    g0 &= mask
+    g1 &= mask
+    g2 &= mask
+    g3 &= mask
+    g4 &= mask
+    nmask := ^mask
+    h0 = (h0 & nmask) | g0
+    h1 = (h1 & nmask) | g1
+    h2 = (h2 & nmask) | g2
+    h3 = (h3 & nmask) | g3
+    h4 = (h4 & nmask) | g4
+
+    // Pack the 130-bit accumulator into four 32-bit little-endian words.
+    h0 |= h1 << 26
+    h1 = (h1 >> 6) | (h2 << 20)
+    h2 = (h2 >> 12) | (h3 << 14)
+    h3 = (h3 >> 18) | (h4 << 8)
+
+    f := uint64(h0) + uint64(p.pad[0])
+    h0 = uint32(f)
+    f = uint64(h1) + uint64(p.pad[1]) + f>>32
+    h1 = uint32(f)
+    f = uint64(h2) + uint64(p.pad[2]) + f>>32
+    h2 = uint32(f)
+    f = uint64(h3) + uint64(p.pad[3]) + f>>32
+    h3 = uint32(f)
+
+    var out [16]byte
+    binary.LittleEndian.PutUint32(out[0:], h0)
+    binary.LittleEndian.PutUint32(out[4:], h1)
+    binary.LittleEndian.PutUint32(out[8:], h2)
+    binary.LittleEndian.PutUint32(out[12:], h3)
+    return out
+}
+
+// poly1305Sum computes the Poly1305 tag of msg under the one-time key.
+func poly1305Sum(key [32]byte, msg []byte) [16]byte {
+    p := newPoly1305(key)
+    p.update(msg)
+    return p.tag()
+}